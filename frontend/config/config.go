@@ -0,0 +1,87 @@
+package config
+
+import "time"
+
+// Config holds the frontend service's runtime configuration, populated
+// from the environment/flags at startup.
+type Config struct {
+	ProjectID string
+	Topic     string
+
+	MaxPubSubGoroutinesAmount      int
+	MaxPubSubGoroutineIdleDuration time.Duration
+
+	PubsubMaxBatch        int
+	PubsubMaxPublishDelay time.Duration
+	// PubsubMaxBatchBytes flushes the pending batch early if adding the
+	// next event's serialized bytes would exceed it. 0 disables the check.
+	PubsubMaxBatchBytes int
+
+	// MaxInFlightBatches bounds how many Publish calls may be in flight at
+	// once; Run blocks (applying backpressure to bqEvents) once the bound
+	// is reached. 0 means unbounded.
+	MaxInFlightBatches int
+	// ShutdownFlushTimeout bounds how long Run's shutdown path spends on
+	// the final pending batch (waiting for an in-flight slot, then
+	// publishing and retrying it) before giving up on it. 0 uses a
+	// built-in default.
+	ShutdownFlushTimeout time.Duration
+
+	// Backend selects which messaging transport the publisher talks to.
+	// One of "gcp", "nats", "kafka". Defaults to "gcp" when empty.
+	Backend string
+
+	NATS  NATSConfig
+	Kafka KafkaConfig
+
+	// CloudEventsEnabled wraps outbound events in a CloudEvents v1.0
+	// envelope instead of publishing the raw event JSON.
+	CloudEventsEnabled bool
+	// Source is the CloudEvents "source" attribute for events this
+	// frontend emits.
+	Source string
+
+	// DedupCacheSize bounds how many idempotency keys are remembered for
+	// duplicate detection, and DedupCacheTTL is how long each one is
+	// remembered. Both must be set to a positive value to enable
+	// deduplication; leaving either at its zero value disables it
+	// entirely (rather than remembering keys forever).
+	DedupCacheSize int
+	DedupCacheTTL  time.Duration
+
+	// UnknownEventTopic, if set, is where events with a name/version not
+	// present in the types.EventRegistry are republished instead of
+	// going through the normal publish path. Left empty (the default),
+	// registry lookup is purely best-effort: these events are still
+	// published normally, just without dedup.
+	UnknownEventTopic string
+
+	// RetryMaxAttempts is how many publish attempts, the first one
+	// included, a message gets before it is handed to the DeadLetterSink.
+	// Values below 1 mean a single attempt with no retry.
+	RetryMaxAttempts int
+	// RetryInitialBackoff and RetryMaxBackoff bound the exponential
+	// backoff applied between retry attempts.
+	RetryInitialBackoff time.Duration
+	RetryMaxBackoff     time.Duration
+	// RetryJitter is a fraction (e.g. 0.2 for up to +20%) of random jitter
+	// added on top of the computed backoff.
+	RetryJitter float64
+
+	// DeadLetterSink selects where exhausted messages go: "topic", "file"
+	// or "none" (the default).
+	DeadLetterSink     string
+	DeadLetterTopic    string
+	DeadLetterFilePath string
+}
+
+// NATSConfig holds connection settings for the NATS JetStream backend.
+type NATSConfig struct {
+	URL    string
+	Stream string
+}
+
+// KafkaConfig holds connection settings for the Kafka backend.
+type KafkaConfig struct {
+	Brokers []string
+}
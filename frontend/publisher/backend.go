@@ -0,0 +1,56 @@
+package publisher
+
+import (
+	"context"
+	"fmt"
+
+	cfg "github.com/doitintl/banias/frontend/config"
+	"go.uber.org/zap"
+)
+
+// Message is a transport-agnostic envelope for an outbound event. It is
+// translated into the broker-specific message type by each Backend.
+type Message struct {
+	Data        []byte
+	Attributes  map[string]string
+	OrderingKey string
+}
+
+// Result is the outcome of publishing a single Message.
+type Result struct {
+	ID  string
+	Err error
+}
+
+// Backend abstracts the messaging transport a Publisher writes to, so that
+// Publisher.Run and Publisher.Publish only ever depend on this interface
+// rather than a concrete broker client.
+type Backend interface {
+	// Publish sends messages and returns one Result per input message, in
+	// the same order.
+	Publish(ctx context.Context, messages []Message) []Result
+	// EnsureTopic creates the given topic/stream if it does not already
+	// exist.
+	EnsureTopic(name string) error
+	// Close releases any resources held by the backend.
+	Close() error
+}
+
+// NewBackend selects and constructs a Backend from config.Backend. An empty
+// config.Backend is normalized to "gcp" in place, so callers that read
+// config.Backend back afterwards (e.g. to label metrics) never see "".
+func NewBackend(config *cfg.Config, logger *zap.Logger) (Backend, error) {
+	if config.Backend == "" {
+		config.Backend = "gcp"
+	}
+	switch config.Backend {
+	case "gcp":
+		return newGCPBackend(config, logger)
+	case "nats":
+		return newNATSBackend(config, logger)
+	case "kafka":
+		return newKafkaBackend(config, logger)
+	default:
+		return nil, fmt.Errorf("publisher: unknown backend %q", config.Backend)
+	}
+}
@@ -0,0 +1,81 @@
+package publisher
+
+import (
+	"context"
+
+	gpubsub "cloud.google.com/go/pubsub"
+	cfg "github.com/doitintl/banias/frontend/config"
+	"go.uber.org/zap"
+)
+
+// gcpBackend publishes to a GCP Pub/Sub topic.
+type gcpBackend struct {
+	client *gpubsub.Client
+	topic  *gpubsub.Topic
+	logger *zap.Logger
+}
+
+func newGCPBackend(config *cfg.Config, logger *zap.Logger) (Backend, error) {
+	ctx := context.Background()
+	client, err := gpubsub.NewClient(ctx, config.ProjectID)
+	if err != nil {
+		logger.Error("Pub/Sub client creation error", zap.Error(err))
+		return nil, err
+	}
+	b := &gcpBackend{client: client, logger: logger}
+	if err := b.EnsureTopic(config.Topic); err != nil {
+		return b, err
+	}
+	return b, nil
+}
+
+func (b *gcpBackend) EnsureTopic(name string) error {
+	ctx := context.Background()
+	t := b.client.Topic(name)
+	ok, err := t.Exists(ctx)
+	if err != nil {
+		b.logger.Error("Pub/Sub topic exists error", zap.Error(err))
+		return err
+	}
+	if ok {
+		b.logger.Info("Topic exists we are all good!")
+		t.EnableMessageOrdering = true
+		b.topic = t
+		return nil
+	}
+	t, err = b.client.CreateTopic(ctx, name)
+	if err != nil {
+		return err
+	}
+	b.logger.Info("New topic created")
+	t.EnableMessageOrdering = true
+	b.topic = t
+	return nil
+}
+
+func (b *gcpBackend) Publish(ctx context.Context, messages []Message) []Result {
+	results := make([]*gpubsub.PublishResult, len(messages))
+	for i := range messages {
+		results[i] = b.topic.Publish(ctx, &gpubsub.Message{
+			Data:        messages[i].Data,
+			Attributes:  messages[i].Attributes,
+			OrderingKey: messages[i].OrderingKey,
+		})
+	}
+	out := make([]Result, len(results))
+	for i, r := range results {
+		id, err := r.Get(ctx)
+		if err != nil && messages[i].OrderingKey != "" {
+			// Resume publishing on this ordering key so a single failure
+			// doesn't permanently block messages from the same sender.
+			b.topic.ResumePublish(messages[i].OrderingKey)
+		}
+		out[i] = Result{ID: id, Err: err}
+	}
+	return out
+}
+
+func (b *gcpBackend) Close() error {
+	b.topic.Stop()
+	return b.client.Close()
+}
@@ -0,0 +1,105 @@
+package publisher
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	cfg "github.com/doitintl/banias/frontend/config"
+	"github.com/segmentio/kafka-go"
+	"go.uber.org/zap"
+)
+
+// kafkaBackend publishes to a Kafka topic.
+type kafkaBackend struct {
+	conn    *kafka.Conn
+	writer  *kafka.Writer
+	brokers []string
+	logger  *zap.Logger
+}
+
+func newKafkaBackend(config *cfg.Config, logger *zap.Logger) (Backend, error) {
+	if len(config.Kafka.Brokers) == 0 {
+		return nil, fmt.Errorf("publisher: kafka backend requires at least one broker")
+	}
+	conn, err := kafka.Dial("tcp", config.Kafka.Brokers[0])
+	if err != nil {
+		logger.Error("Kafka dial error", zap.Error(err))
+		return nil, err
+	}
+	b := &kafkaBackend{
+		conn:    conn,
+		brokers: config.Kafka.Brokers,
+		logger:  logger,
+	}
+	if err := b.EnsureTopic(config.Topic); err != nil {
+		return b, err
+	}
+	b.writer = &kafka.Writer{
+		Addr:     kafka.TCP(config.Kafka.Brokers...),
+		Topic:    config.Topic,
+		Balancer: &kafka.Hash{},
+	}
+	return b, nil
+}
+
+func (b *kafkaBackend) EnsureTopic(name string) error {
+	controller, err := b.conn.Controller()
+	if err != nil {
+		return err
+	}
+	controllerConn, err := kafka.Dial("tcp", fmt.Sprintf("%s:%d", controller.Host, controller.Port))
+	if err != nil {
+		return err
+	}
+	defer controllerConn.Close()
+	err = controllerConn.CreateTopics(kafka.TopicConfig{
+		Topic:             name,
+		NumPartitions:     1,
+		ReplicationFactor: 1,
+	})
+	if err != nil {
+		return err
+	}
+	b.logger.Info("Topic ensured")
+	return nil
+}
+
+func (b *kafkaBackend) Publish(ctx context.Context, messages []Message) []Result {
+	kmsgs := make([]kafka.Message, len(messages))
+	for i, m := range messages {
+		kmsgs[i] = kafka.Message{Key: []byte(m.OrderingKey), Value: m.Data}
+	}
+	err := b.writer.WriteMessages(ctx, kmsgs...)
+	return mapKafkaResults(len(messages), err)
+}
+
+// mapKafkaResults turns the error from a single WriteMessages call into
+// one Result per message. kafka-go reports partial batch failures as a
+// kafka.WriteErrors slice aligned with the input messages (a nil entry
+// meaning that message succeeded); any other error is treated as having
+// failed the whole batch.
+func mapKafkaResults(n int, err error) []Result {
+	out := make([]Result, n)
+	if err == nil {
+		return out
+	}
+	var writeErrors kafka.WriteErrors
+	if errors.As(err, &writeErrors) {
+		for i := range out {
+			if i < len(writeErrors) {
+				out[i] = Result{Err: writeErrors[i]}
+			}
+		}
+		return out
+	}
+	for i := range out {
+		out[i] = Result{Err: err}
+	}
+	return out
+}
+
+func (b *kafkaBackend) Close() error {
+	b.conn.Close()
+	return b.writer.Close()
+}
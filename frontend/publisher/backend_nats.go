@@ -0,0 +1,74 @@
+package publisher
+
+import (
+	"context"
+
+	cfg "github.com/doitintl/banias/frontend/config"
+	"github.com/nats-io/nats.go"
+	"go.uber.org/zap"
+)
+
+// natsBackend publishes to a NATS JetStream stream.
+type natsBackend struct {
+	conn    *nats.Conn
+	js      nats.JetStreamContext
+	subject string
+	logger  *zap.Logger
+}
+
+func newNATSBackend(config *cfg.Config, logger *zap.Logger) (Backend, error) {
+	conn, err := nats.Connect(config.NATS.URL)
+	if err != nil {
+		logger.Error("NATS connect error", zap.Error(err))
+		return nil, err
+	}
+	js, err := conn.JetStream()
+	if err != nil {
+		logger.Error("NATS JetStream init error", zap.Error(err))
+		return nil, err
+	}
+	b := &natsBackend{conn: conn, js: js, subject: config.NATS.Stream, logger: logger}
+	if err := b.EnsureTopic(config.NATS.Stream); err != nil {
+		return b, err
+	}
+	return b, nil
+}
+
+func (b *natsBackend) EnsureTopic(name string) error {
+	if _, err := b.js.StreamInfo(name); err == nil {
+		b.logger.Info("Stream exists we are all good!")
+		return nil
+	}
+	_, err := b.js.AddStream(&nats.StreamConfig{
+		Name:     name,
+		Subjects: []string{name + ".>"},
+	})
+	if err != nil {
+		return err
+	}
+	b.logger.Info("New stream created")
+	return nil
+}
+
+func (b *natsBackend) Publish(ctx context.Context, messages []Message) []Result {
+	out := make([]Result, len(messages))
+	for i, m := range messages {
+		subject := b.subject
+		if m.OrderingKey != "" {
+			subject = b.subject + "." + m.OrderingKey
+		}
+		ack, err := b.js.Publish(subject, m.Data)
+		if err != nil {
+			b.logger.Error("Error Publishing", zap.Error(err))
+			out[i] = Result{Err: err}
+			continue
+		}
+		out[i] = Result{ID: ack.Stream}
+	}
+	return out
+}
+
+func (b *natsBackend) Close() error {
+	b.conn.Close()
+	return nil
+}
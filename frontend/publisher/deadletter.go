@@ -0,0 +1,144 @@
+package publisher
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	cfg "github.com/doitintl/banias/frontend/config"
+	"github.com/pquerna/ffjson/ffjson"
+	"go.uber.org/zap"
+)
+
+// DeadLetterRecord carries a message that exhausted its retry budget,
+// along with failure metadata for whoever inspects the dead-letter sink.
+type DeadLetterRecord struct {
+	Message   Message
+	Attempts  int
+	LastError string
+	FirstSeen time.Time
+}
+
+// DeadLetterSink is where messages go once Publisher.Publish gives up on
+// retrying them.
+type DeadLetterSink interface {
+	Write(ctx context.Context, record DeadLetterRecord) error
+	// Close releases any resources held by the sink.
+	Close() error
+}
+
+// NewDeadLetterSink selects and constructs a DeadLetterSink from
+// config.DeadLetterSink.
+func NewDeadLetterSink(config *cfg.Config, logger *zap.Logger) (DeadLetterSink, error) {
+	switch config.DeadLetterSink {
+	case "", "none":
+		return noopDeadLetterSink{}, nil
+	case "topic":
+		return newTopicDeadLetterSink(config, logger)
+	case "file":
+		return newFileDeadLetterSink(config.DeadLetterFilePath)
+	default:
+		return nil, fmt.Errorf("publisher: unknown dead-letter sink %q", config.DeadLetterSink)
+	}
+}
+
+// noopDeadLetterSink discards everything written to it.
+type noopDeadLetterSink struct{}
+
+func (noopDeadLetterSink) Write(ctx context.Context, record DeadLetterRecord) error {
+	return nil
+}
+
+func (noopDeadLetterSink) Close() error {
+	return nil
+}
+
+// topicDeadLetterSink republishes failed messages to a secondary topic,
+// tagged with failure metadata as attributes. Only the gcp backend sends
+// Message.Attributes; through the nats and kafka backends the republished
+// message carries its data and ordering key but no failure metadata.
+type topicDeadLetterSink struct {
+	backend Backend
+}
+
+func newTopicDeadLetterSink(config *cfg.Config, logger *zap.Logger) (DeadLetterSink, error) {
+	dlConfig := *config
+	dlConfig.Topic = config.DeadLetterTopic
+	dlConfig.NATS.Stream = config.DeadLetterTopic
+	backend, err := NewBackend(&dlConfig, logger)
+	if err != nil {
+		return nil, err
+	}
+	return &topicDeadLetterSink{backend: backend}, nil
+}
+
+func (s *topicDeadLetterSink) Write(ctx context.Context, record DeadLetterRecord) error {
+	msg := record.Message
+	attrs := make(map[string]string, len(msg.Attributes)+3)
+	for k, v := range msg.Attributes {
+		attrs[k] = v
+	}
+	attrs["attempts"] = fmt.Sprintf("%d", record.Attempts)
+	attrs["last_error"] = record.LastError
+	attrs["first_seen"] = record.FirstSeen.Format(time.RFC3339Nano)
+	msg.Attributes = attrs
+
+	results := s.backend.Publish(ctx, []Message{msg})
+	if len(results) > 0 && results[0].Err != nil {
+		return results[0].Err
+	}
+	return nil
+}
+
+func (s *topicDeadLetterSink) Close() error {
+	return s.backend.Close()
+}
+
+// fileDeadLetterSink appends failed messages as JSON lines to a local file.
+type fileDeadLetterSink struct {
+	mu sync.Mutex
+	f  *os.File
+}
+
+func newFileDeadLetterSink(path string) (DeadLetterSink, error) {
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &fileDeadLetterSink{f: f}, nil
+}
+
+type deadLetterLine struct {
+	Data        []byte            `json:"data"`
+	Attributes  map[string]string `json:"attributes,omitempty"`
+	OrderingKey string            `json:"ordering_key,omitempty"`
+	Attempts    int               `json:"attempts"`
+	LastError   string            `json:"last_error"`
+	FirstSeen   time.Time         `json:"first_seen"`
+}
+
+func (s *fileDeadLetterSink) Write(ctx context.Context, record DeadLetterRecord) error {
+	buf, err := ffjson.Marshal(deadLetterLine{
+		Data:        record.Message.Data,
+		Attributes:  record.Message.Attributes,
+		OrderingKey: record.Message.OrderingKey,
+		Attempts:    record.Attempts,
+		LastError:   record.LastError,
+		FirstSeen:   record.FirstSeen,
+	})
+	if err != nil {
+		return err
+	}
+	buf = append(buf, '\n')
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, err = s.f.Write(buf)
+	return err
+}
+
+func (s *fileDeadLetterSink) Close() error {
+	return s.f.Close()
+}
@@ -0,0 +1,68 @@
+package publisher
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// dedupCache is a bounded, TTL-aware LRU of recently seen idempotency
+// keys, used to drop events that were already published.
+type dedupCache struct {
+	mu       sync.Mutex
+	ttl      time.Duration
+	capacity int
+	ll       *list.List
+	items    map[string]*list.Element
+}
+
+type dedupEntry struct {
+	key  string
+	seen time.Time
+}
+
+func newDedupCache(capacity int, ttl time.Duration) *dedupCache {
+	return &dedupCache{
+		capacity: capacity,
+		ttl:      ttl,
+		ll:       list.New(),
+		items:    make(map[string]*list.Element),
+	}
+}
+
+// SeenRecently reports whether key was already recorded within the TTL. If
+// not (or if the previous sighting has expired), it records key as seen
+// now and returns false. A cache with ttl<=0 or capacity<=0 is disabled:
+// it never reports a duplicate and never retains keys, so a deployment
+// that forgets to configure DedupCacheSize/DedupCacheTTL just gets no
+// deduplication instead of an unbounded key map.
+func (c *dedupCache) SeenRecently(key string) bool {
+	if c.ttl <= 0 || c.capacity <= 0 {
+		return false
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if el, ok := c.items[key]; ok {
+		entry := el.Value.(*dedupEntry)
+		c.ll.MoveToFront(el)
+		if time.Since(entry.seen) < c.ttl {
+			return true
+		}
+		entry.seen = time.Now()
+		return false
+	}
+
+	el := c.ll.PushFront(&dedupEntry{key: key, seen: time.Now()})
+	c.items[key] = el
+
+	if c.capacity > 0 && c.ll.Len() > c.capacity {
+		oldest := c.ll.Back()
+		if oldest != nil {
+			c.ll.Remove(oldest)
+			delete(c.items, oldest.Value.(*dedupEntry).key)
+		}
+	}
+	return false
+}
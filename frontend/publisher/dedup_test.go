@@ -0,0 +1,49 @@
+package publisher
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDedupCacheSeenRecently(t *testing.T) {
+	c := newDedupCache(2, 50*time.Millisecond)
+
+	if c.SeenRecently("a") {
+		t.Fatal("first sighting of a should not be reported as duplicate")
+	}
+	if !c.SeenRecently("a") {
+		t.Fatal("second sighting within TTL should be reported as duplicate")
+	}
+
+	time.Sleep(60 * time.Millisecond)
+	if c.SeenRecently("a") {
+		t.Fatal("sighting after TTL expiry should not be reported as duplicate")
+	}
+}
+
+func TestDedupCacheEviction(t *testing.T) {
+	c := newDedupCache(1, time.Minute)
+	c.SeenRecently("a")
+	c.SeenRecently("b") // evicts "a"
+
+	if c.SeenRecently("a") {
+		t.Fatal("a should have been evicted and not reported as duplicate")
+	}
+	if len(c.items) != 1 {
+		t.Fatalf("want capacity-bounded cache size 1, got %d", len(c.items))
+	}
+}
+
+func TestDedupCacheDisabledWhenUnconfigured(t *testing.T) {
+	c := newDedupCache(0, 0)
+
+	if c.SeenRecently("a") {
+		t.Fatal("a zero-value cache should be disabled, never reporting duplicates")
+	}
+	if c.SeenRecently("a") {
+		t.Fatal("a zero-value cache should still be disabled on second lookup")
+	}
+	if len(c.items) != 0 {
+		t.Fatalf("a disabled cache should never retain keys, got %d", len(c.items))
+	}
+}
@@ -0,0 +1,36 @@
+package publisher
+
+import "context"
+
+// fakeBackend is a Backend whose Publish behavior is supplied by the test.
+type fakeBackend struct {
+	publishFunc func(ctx context.Context, messages []Message) []Result
+	closed      bool
+}
+
+func (b *fakeBackend) Publish(ctx context.Context, messages []Message) []Result {
+	return b.publishFunc(ctx, messages)
+}
+
+func (b *fakeBackend) EnsureTopic(name string) error { return nil }
+
+func (b *fakeBackend) Close() error {
+	b.closed = true
+	return nil
+}
+
+// fakeDeadLetterSink records every record written to it.
+type fakeDeadLetterSink struct {
+	records []DeadLetterRecord
+	closed  bool
+}
+
+func (s *fakeDeadLetterSink) Write(ctx context.Context, record DeadLetterRecord) error {
+	s.records = append(s.records, record)
+	return nil
+}
+
+func (s *fakeDeadLetterSink) Close() error {
+	s.closed = true
+	return nil
+}
@@ -0,0 +1,79 @@
+package publisher
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+func TestPublishWithRetryExhaustsToDeadLetter(t *testing.T) {
+	attempts := 0
+	backend := &fakeBackend{publishFunc: func(ctx context.Context, messages []Message) []Result {
+		attempts++
+		out := make([]Result, len(messages))
+		for i := range out {
+			out[i] = Result{Err: errors.New("boom")}
+		}
+		return out
+	}}
+	sink := &fakeDeadLetterSink{}
+	p := &Publisher{
+		logger:     zap.NewNop(),
+		backend:    backend,
+		retry:      retryPolicy{maxAttempts: 2, initialBackoff: time.Millisecond, maxBackoff: time.Millisecond},
+		deadLetter: sink,
+	}
+
+	errnum := p.publishWithRetry(context.Background(), []Message{{Data: []byte("x")}})
+
+	if errnum != 1 {
+		t.Fatalf("want 1 failure, got %d", errnum)
+	}
+	if attempts != 2 {
+		t.Fatalf("want 2 attempts (maxAttempts), got %d", attempts)
+	}
+	if len(sink.records) != 1 {
+		t.Fatalf("want 1 dead-lettered record, got %d", len(sink.records))
+	}
+	if sink.records[0].Attempts != 2 {
+		t.Fatalf("want recorded attempts=2, got %d", sink.records[0].Attempts)
+	}
+	if sink.records[0].LastError != "boom" {
+		t.Fatalf("want recorded last error %q, got %q", "boom", sink.records[0].LastError)
+	}
+}
+
+func TestPublishWithRetrySucceedsAfterTransientFailure(t *testing.T) {
+	attempts := 0
+	backend := &fakeBackend{publishFunc: func(ctx context.Context, messages []Message) []Result {
+		attempts++
+		out := make([]Result, len(messages))
+		if attempts == 1 {
+			out[0] = Result{Err: errors.New("transient")}
+			return out
+		}
+		return out
+	}}
+	sink := &fakeDeadLetterSink{}
+	p := &Publisher{
+		logger:     zap.NewNop(),
+		backend:    backend,
+		retry:      retryPolicy{maxAttempts: 3, initialBackoff: time.Millisecond, maxBackoff: time.Millisecond},
+		deadLetter: sink,
+	}
+
+	errnum := p.publishWithRetry(context.Background(), []Message{{Data: []byte("x")}})
+
+	if errnum != 0 {
+		t.Fatalf("want 0 failures after eventual success, got %d", errnum)
+	}
+	if attempts != 2 {
+		t.Fatalf("want 2 attempts (1 failure + 1 success), got %d", attempts)
+	}
+	if len(sink.records) != 0 {
+		t.Fatalf("want no dead-lettered records, got %d", len(sink.records))
+	}
+}
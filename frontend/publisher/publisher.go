@@ -5,7 +5,6 @@ import (
 	"sync"
 	"time"
 
-	gpubsub "cloud.google.com/go/pubsub"
 	cfg "github.com/doitintl/banias/frontend/config"
 	"github.com/doitintl/banias/frontend/types"
 	"github.com/henrylee2cn/goutil/pool"
@@ -14,8 +13,14 @@ import (
 	"go.uber.org/zap"
 )
 
+// defaultShutdownFlushTimeout bounds how long Run's shutdown path spends
+// on the final pending batch (waiting for a free in-flight slot, then
+// publishing and retrying it), used when config.ShutdownFlushTimeout isn't
+// set.
+const defaultShutdownFlushTimeout = 5 * time.Second
+
 var (
-	promLabelNames = []string{"function"}
+	promLabelNames = []string{"function", "backend"}
 	publishCounter = prometheus.NewCounterVec(
 		prometheus.CounterOpts(prometheus.Opts{
 			Namespace: "banias",
@@ -29,104 +34,157 @@ var (
 		Name:      "pubsub_publish_duration_milliseconds",
 		Help:      "pubsub publish duration (ms)",
 	}, promLabelNames)
+	dedupDroppedCounter = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: "banias",
+		Subsystem: "publisher",
+		Name:      "dedup_dropped_total",
+		Help:      "events dropped because their idempotency key was seen recently",
+	})
+	unknownEventCounter = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "banias",
+		Subsystem: "publisher",
+		Name:      "unknown_event_total",
+		Help:      "events whose name/version is not in the types.EventRegistry",
+	}, []string{"action"})
+	retryCounter = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "banias",
+		Subsystem: "publisher",
+		Name:      "retry_total",
+		Help:      "publish retries, by outcome",
+	}, []string{"outcome"})
+	deadletterCounter = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: "banias",
+		Subsystem: "publisher",
+		Name:      "deadletter_total",
+		Help:      "messages written to the dead-letter sink after exhausting retries",
+	})
+	inflightBatchesGauge = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: "banias",
+		Subsystem: "publisher",
+		Name:      "inflight_batches",
+		Help:      "publish batches currently in flight",
+	})
+	batchBytesHistogram = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: "banias",
+		Subsystem: "publisher",
+		Name:      "batch_bytes",
+		Help:      "serialized size of published batches, in bytes",
+		Buckets:   prometheus.ExponentialBuckets(1024, 2, 10),
+	})
 )
 
 func init() {
 
 	prometheus.MustRegister(publishCounter)
 	prometheus.MustRegister(publishTimeSummary)
+	prometheus.MustRegister(dedupDroppedCounter)
+	prometheus.MustRegister(unknownEventCounter)
+	prometheus.MustRegister(retryCounter)
+	prometheus.MustRegister(deadletterCounter)
+	prometheus.MustRegister(inflightBatchesGauge)
+	prometheus.MustRegister(batchBytesHistogram)
 
 }
 
 type Publisher struct {
-	bqEvents      <-chan types.EventMsg
-	logger        *zap.Logger
-	gp            *pool.GoPool
-	gpubsubClient gpubsub.Client
-	config        *cfg.Config
-	topic         *gpubsub.Topic
-	client        *gpubsub.Client
-	wg            *sync.WaitGroup
-	id            int
+	bqEvents       <-chan types.EventMsg
+	logger         *zap.Logger
+	gp             *pool.GoPool
+	config         *cfg.Config
+	backend        Backend
+	unknownBackend Backend
+	dedup          *dedupCache
+	retry          retryPolicy
+	deadLetter     DeadLetterSink
+	inflight       chan struct{}
+	wg             *sync.WaitGroup
+	id             int
 }
 
-func createTopicIfNotExists(projectid string, topic string, logger *zap.Logger) (*gpubsub.Topic, error) {
-	ctx := context.Background()
-	client, err := gpubsub.NewClient(ctx, projectid)
+func NewPublisher(logger *zap.Logger, bqEvents <-chan types.EventMsg, config *cfg.Config, id int) (*Publisher, error) {
+	logger.Debug("Creating a new publisher", zap.Int("id", id))
+	gp := pool.NewGoPool(config.MaxPubSubGoroutinesAmount, config.MaxPubSubGoroutineIdleDuration)
+	backend, err := NewBackend(config, logger)
 	if err != nil {
-		logger.Error("Pub/Sub client creation error", zap.Error(err))
+		logger.Error("Error creating backend", zap.Error(err))
 		return nil, err
 	}
-	// Create a topic to subscribe to.
-	t := client.Topic(topic)
-	ok, err := t.Exists(ctx)
-	if err != nil {
-		logger.Error("Pub/Sub topic exists error", zap.Error(err))
-		return t, err
+	logger.Debug("Done with backend")
+	deadLetter, dlErr := NewDeadLetterSink(config, logger)
+	if dlErr != nil {
+		logger.Error("Error creating dead-letter sink", zap.Error(dlErr))
+		deadLetter = noopDeadLetterSink{}
 	}
-	if ok {
-		logger.Info("Topic exists we are all good!")
-		return t, err
+	p := Publisher{
+		bqEvents:   bqEvents,
+		logger:     logger,
+		gp:         gp,
+		config:     config,
+		backend:    backend,
+		dedup:      newDedupCache(config.DedupCacheSize, config.DedupCacheTTL),
+		retry:      newRetryPolicy(config),
+		deadLetter: deadLetter,
+		wg:         new(sync.WaitGroup),
+		id:         id,
 	}
-	t, err = client.CreateTopic(ctx, topic)
-	if err != nil {
-		return t, err
+	if config.MaxInFlightBatches > 0 {
+		p.inflight = make(chan struct{}, config.MaxInFlightBatches)
 	}
-	logger.Info("New topic created")
-	return t, err
-}
-
-func NewPublisher(logger *zap.Logger, bqEvents <-chan types.EventMsg, config *cfg.Config, id int) (*Publisher, error) {
-	logger.Debug("Creating a new publisher", zap.Int("id", id))
-	gp := pool.NewGoPool(config.MaxPubSubGoroutinesAmount, config.MaxPubSubGoroutineIdleDuration)
-	topic, err := createTopicIfNotExists(config.ProjectID, config.Topic, logger)
-	logger.Debug("Done with topic")
-	p := Publisher{
-		bqEvents: bqEvents,
-		logger:   logger,
-		gp:       gp,
-		config:   config,
-		topic:    topic,
-		wg:       new(sync.WaitGroup),
-		id:       id,
+	if config.UnknownEventTopic != "" {
+		unknownConfig := *config
+		unknownConfig.Topic = config.UnknownEventTopic
+		unknownConfig.NATS.Stream = config.UnknownEventTopic
+		unknownBackend, uerr := NewBackend(&unknownConfig, logger)
+		if uerr != nil {
+			logger.Error("Error creating unknown-event backend", zap.Error(uerr))
+		} else {
+			p.unknownBackend = unknownBackend
+		}
 	}
 	logger.Debug("Done with publisher struct!")
-	if err != nil {
-		logger.Error("Error creating topic", zap.Error(err))
-	}
 	logger.Debug("Done with NewPublisher")
-	return &p, err
+	return &p, nil
 }
 
-func (c *Publisher) Publish(messages []gpubsub.Message, t *time.Timer, maxDelay time.Duration, ) {
+// Publish submits a batch for publishing. If MaxInFlightBatches is set,
+// this blocks until a slot is free, which in turn applies backpressure to
+// whatever is feeding Run's bqEvents channel. The wait for a free slot
+// honors ctx, so a cancelled/expired ctx (e.g. a shutdown grace period)
+// bounds how long this can block instead of hanging forever.
+func (c *Publisher) Publish(ctx context.Context, messages []Message, t *time.Timer, maxDelay time.Duration) {
+	var batchBytes int
+	for _, m := range messages {
+		batchBytes += len(m.Data)
+	}
+	batchBytesHistogram.Observe(float64(batchBytes))
+
+	if c.inflight != nil {
+		select {
+		case c.inflight <- struct{}{}:
+		case <-ctx.Done():
+			c.logger.Error("Giving up waiting for an inflight slot",
+				zap.Error(ctx.Err()), zap.Int("Number of message", len(messages)))
+			return
+		}
+	}
+	inflightBatchesGauge.Inc()
 	c.wg.Add(1)
 	c.gp.Go(func() {
 		defer func(begin time.Time) {
-			promLabels := prometheus.Labels{"function": "Publish"}
+			promLabels := prometheus.Labels{"function": "Publish", "backend": c.config.Backend}
 			responseTime := time.Since(begin).Seconds() * 1000
 			publishTimeSummary.With(promLabels).Observe(responseTime)
-
+			inflightBatchesGauge.Dec()
+			if c.inflight != nil {
+				<-c.inflight
+			}
 		}(time.Now())
 
-		var total int64 = 0
-		var errnum int64 = 0
-		ctx := context.Background()
-		var results []*gpubsub.PublishResult
-		for i := range messages {
-			r := c.topic.Publish(ctx, &messages[i])
-			total++
-			results = append(results, r)
-		}
-		for _, r := range results {
-			id, err := r.Get(ctx)
-			if err != nil {
-				c.logger.Error("Error Publishing", zap.Error(err), zap.String("ID", id))
-				errnum++
-			}
-		}
+		total := int64(len(messages))
+		errnum := c.publishWithRetry(ctx, messages)
 
 		messages = nil
-		promLabels := prometheus.Labels{"function": "Publish"}
+		promLabels := prometheus.Labels{"function": "Publish", "backend": c.config.Backend}
 		publishCounter.With(promLabels).Add(float64(total))
 		c.logger.Info("Published ", zap.Int64("Success", total-errnum), zap.Int64("Failures", errnum))
 		t.Reset(maxDelay)
@@ -135,12 +193,144 @@ func (c *Publisher) Publish(messages []gpubsub.Message, t *time.Timer, maxDelay
 
 }
 
-func (c *Publisher) Run() {
+// pendingPublish tracks a message across retry attempts.
+type pendingPublish struct {
+	message   Message
+	attempts  int
+	firstSeen time.Time
+	lastError error
+}
+
+// publishWithRetry publishes messages, retrying individual failures with
+// backoff up to c.retry.maxAttempts, and sending anything still failing
+// after that to c.deadLetter. It returns the number of messages that
+// ultimately failed. ctx bounds both the backend calls and the backoff
+// waits between them, so a cancelled/expired ctx (e.g. a shutdown grace
+// period) stops the retry loop instead of letting it run to completion.
+func (c *Publisher) publishWithRetry(ctx context.Context, messages []Message) int64 {
+	now := time.Now()
+	pending := make([]*pendingPublish, len(messages))
+	for i, m := range messages {
+		pending[i] = &pendingPublish{message: m, firstSeen: now}
+	}
+
+	var errnum int64
+	for attempt := 0; len(pending) > 0; attempt++ {
+		batch := make([]Message, len(pending))
+		for i, p := range pending {
+			batch[i] = p.message
+		}
+		results := c.backend.Publish(ctx, batch)
+
+		var retry []*pendingPublish
+		for i, r := range results {
+			pending[i].attempts++
+			if r.Err == nil {
+				continue
+			}
+			pending[i].lastError = r.Err
+			c.logger.Error("Error Publishing", zap.Error(r.Err), zap.String("ID", r.ID),
+				zap.Int("attempt", pending[i].attempts))
+			if pending[i].attempts < c.retry.maxAttempts {
+				retry = append(retry, pending[i])
+				continue
+			}
+			errnum++
+			retryCounter.With(prometheus.Labels{"outcome": "exhausted"}).Inc()
+			c.sendToDeadLetter(ctx, pending[i])
+		}
+		if len(retry) == 0 {
+			break
+		}
+		retryCounter.With(prometheus.Labels{"outcome": "retried"}).Add(float64(len(retry)))
+		select {
+		case <-time.After(c.retry.backoff(attempt)):
+		case <-ctx.Done():
+			for _, p := range retry {
+				p.lastError = ctx.Err()
+				errnum++
+				retryCounter.With(prometheus.Labels{"outcome": "exhausted"}).Inc()
+				c.sendToDeadLetter(ctx, p)
+			}
+			return errnum
+		}
+		pending = retry
+	}
+	return errnum
+}
+
+// sendToDeadLetter hands a message that exhausted its retries to c.deadLetter.
+func (c *Publisher) sendToDeadLetter(ctx context.Context, p *pendingPublish) {
+	lastError := ""
+	if p.lastError != nil {
+		lastError = p.lastError.Error()
+	}
+	record := DeadLetterRecord{
+		Message:   p.message,
+		Attempts:  p.attempts,
+		LastError: lastError,
+		FirstSeen: p.firstSeen,
+	}
+	if err := c.deadLetter.Write(ctx, record); err != nil {
+		c.logger.Error("Error writing to dead-letter sink", zap.Error(err))
+		return
+	}
+	deadletterCounter.Inc()
+}
+
+// handleUnknownEvent republishes an event whose name/version isn't in the
+// types.EventRegistry to c.unknownBackend. Only called when one is
+// configured; the registry lookup itself is best-effort and never gates
+// normal publishing, so an unconfigured UnknownEventTopic just means these
+// events flow through the regular path instead.
+func (c *Publisher) handleUnknownEvent(event types.EventMsg) {
+	buf, err := ffjson.Marshal(event)
+	if err != nil {
+		c.logger.Error("Error Marshaling unknown event", zap.Error(err))
+		return
+	}
+	unknownEventCounter.With(prometheus.Labels{"action": "routed"}).Inc()
+	c.unknownBackend.Publish(context.Background(), []Message{{Data: buf, OrderingKey: event.SenderID}})
+}
+
+// Run consumes bqEvents and publishes them in batches until ctx is
+// cancelled, at which point it flushes whatever is pending, waits for all
+// in-flight Publish calls to finish, closes the backend (and the
+// unknown-event backend and dead-letter sink, if configured) and returns.
+func (c *Publisher) Run(ctx context.Context) {
 	c.logger.Debug("Starting Run")
-	messages := make([]gpubsub.Message, 0, c.config.PubsubMaxBatch)
+	messages := make([]Message, 0, c.config.PubsubMaxBatch)
+	batchBytes := 0
 	t := time.NewTimer(c.config.PubsubMaxPublishDelay)
 	for {
 		select {
+		case <-ctx.Done():
+			c.logger.Debug("Run stopping, flushing pending batch", zap.Int("Number of message", len(messages)))
+			cancelFlush := context.CancelFunc(func() {})
+			if len(messages) > 0 {
+				flushTimeout := c.config.ShutdownFlushTimeout
+				if flushTimeout <= 0 {
+					flushTimeout = defaultShutdownFlushTimeout
+				}
+				flushCtx, cancel := context.WithTimeout(context.Background(), flushTimeout)
+				cancelFlush = cancel
+				c.Publish(flushCtx, messages, t, c.config.PubsubMaxPublishDelay)
+			}
+			c.wg.Wait()
+			cancelFlush()
+			if err := c.backend.Close(); err != nil {
+				c.logger.Error("Error closing backend", zap.Error(err))
+			}
+			if c.unknownBackend != nil {
+				if err := c.unknownBackend.Close(); err != nil {
+					c.logger.Error("Error closing unknown-event backend", zap.Error(err))
+				}
+			}
+			if err := c.deadLetter.Close(); err != nil {
+				c.logger.Error("Error closing dead-letter sink", zap.Error(err))
+			}
+			return
+
 		case <-t.C:
 			if len(messages) == 0 {
 				c.logger.Debug("skipping publish due to no messages")
@@ -148,24 +338,57 @@ func (c *Publisher) Run() {
 				continue
 			}
 			c.logger.Debug("Calling publish due to time", zap.Int("Number of message", len(messages)), zap.Int("Aggrigator ID", c.id))
-			c.Publish(messages, t, c.config.PubsubMaxPublishDelay)
+			c.Publish(ctx, messages, t, c.config.PubsubMaxPublishDelay)
 			messages = nil
-
-
+			batchBytes = 0
 
 		case event := <-c.bqEvents:
 
-			buf, err := ffjson.Marshal(event)
+			// Registry lookup is best-effort: it only enables dedup and,
+			// optionally, routing to a dedicated unknown-event topic. An
+			// event with an unregistered name/version is still published
+			// through the normal path below.
+			if registered, known := types.Registry().Lookup(event.Event.TypeField); known {
+				idempotencyKey := registered.IdempotencyKey(event.Event.PayloadField)
+				if c.dedup.SeenRecently(idempotencyKey) {
+					dedupDroppedCounter.Inc()
+					c.logger.Debug("Dropping duplicate event", zap.String("idempotency_key", idempotencyKey))
+					continue
+				}
+			} else if c.unknownBackend != nil {
+				c.handleUnknownEvent(event)
+				continue
+			} else {
+				unknownEventCounter.With(prometheus.Labels{"action": "passthrough"}).Inc()
+			}
+
+			var buf []byte
+			var err error
+			if c.config.CloudEventsEnabled {
+				buf, err = ffjson.Marshal(types.NewCloudEvent(c.config.Source, event))
+			} else {
+				buf, err = ffjson.Marshal(event)
+			}
 			if err != nil {
 				c.logger.Error("Error Marshaling event", zap.Error(err))
 				continue
 			}
-			messages = append(messages, gpubsub.Message{Data: buf})
+
+			if c.config.PubsubMaxBatchBytes > 0 && len(messages) > 0 && batchBytes+len(buf) > c.config.PubsubMaxBatchBytes {
+				c.logger.Debug("Calling publish due to byte size", zap.Int("batch_bytes", batchBytes), zap.Int("Aggrigator ID", c.id))
+				c.Publish(ctx, messages, t, c.config.PubsubMaxPublishDelay)
+				messages = make([]Message, 0, c.config.PubsubMaxBatch)
+				batchBytes = 0
+			}
+
+			messages = append(messages, Message{Data: buf, OrderingKey: event.SenderID})
+			batchBytes += len(buf)
 			if len(messages) == c.config.PubsubMaxBatch {
 
 				c.logger.Debug("Calling publish due to capacity ", zap.Int("Number of message", len(messages)), zap.Int("Aggrigator ID", c.id))
-				c.Publish(messages, t, c.config.PubsubMaxPublishDelay)
+				c.Publish(ctx, messages, t, c.config.PubsubMaxPublishDelay)
 				messages = nil
+				batchBytes = 0
 
 			}
 		}
@@ -0,0 +1,40 @@
+package publisher
+
+import (
+	"math/rand"
+	"time"
+
+	cfg "github.com/doitintl/banias/frontend/config"
+)
+
+// retryPolicy controls how many times a failed publish is retried and how
+// long to back off between attempts.
+type retryPolicy struct {
+	maxAttempts    int
+	initialBackoff time.Duration
+	maxBackoff     time.Duration
+	jitter         float64
+}
+
+func newRetryPolicy(config *cfg.Config) retryPolicy {
+	return retryPolicy{
+		maxAttempts:    config.RetryMaxAttempts,
+		initialBackoff: config.RetryInitialBackoff,
+		maxBackoff:     config.RetryMaxBackoff,
+		jitter:         config.RetryJitter,
+	}
+}
+
+// backoff returns how long to wait before retry attempt number `attempt`
+// (0-based), with exponential growth capped at maxBackoff and a random
+// jitter fraction added on top.
+func (p retryPolicy) backoff(attempt int) time.Duration {
+	d := p.initialBackoff << uint(attempt)
+	if d <= 0 || d > p.maxBackoff {
+		d = p.maxBackoff
+	}
+	if p.jitter > 0 {
+		d += time.Duration(rand.Float64() * p.jitter * float64(d))
+	}
+	return d
+}
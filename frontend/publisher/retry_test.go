@@ -0,0 +1,24 @@
+package publisher
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRetryPolicyBackoffCapped(t *testing.T) {
+	p := retryPolicy{
+		maxAttempts:    5,
+		initialBackoff: 10 * time.Millisecond,
+		maxBackoff:     30 * time.Millisecond,
+	}
+
+	if got := p.backoff(0); got != 10*time.Millisecond {
+		t.Fatalf("attempt 0: got %v, want %v", got, 10*time.Millisecond)
+	}
+	if got := p.backoff(1); got != 20*time.Millisecond {
+		t.Fatalf("attempt 1: got %v, want %v", got, 20*time.Millisecond)
+	}
+	if got := p.backoff(5); got != 30*time.Millisecond {
+		t.Fatalf("attempt 5 should be capped at maxBackoff: got %v, want %v", got, 30*time.Millisecond)
+	}
+}
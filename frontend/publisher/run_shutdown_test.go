@@ -0,0 +1,181 @@
+package publisher
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	cfg "github.com/doitintl/banias/frontend/config"
+	"github.com/doitintl/banias/frontend/types"
+	"github.com/henrylee2cn/goutil/pool"
+	"go.uber.org/zap"
+)
+
+func TestRunFlushesPendingBatchOnShutdown(t *testing.T) {
+	published := make(chan []Message, 1)
+	backend := &fakeBackend{publishFunc: func(ctx context.Context, messages []Message) []Result {
+		published <- messages
+		return make([]Result, len(messages))
+	}}
+
+	unknownBackend := &fakeBackend{publishFunc: func(ctx context.Context, messages []Message) []Result {
+		return make([]Result, len(messages))
+	}}
+	deadLetter := &fakeDeadLetterSink{}
+
+	bqEvents := make(chan types.EventMsg, 1)
+	config := &cfg.Config{
+		PubsubMaxBatch:        10,
+		PubsubMaxPublishDelay: time.Hour,
+		ShutdownFlushTimeout:  time.Second,
+	}
+	p := &Publisher{
+		bqEvents:       bqEvents,
+		logger:         zap.NewNop(),
+		gp:             pool.NewGoPool(1, time.Second),
+		config:         config,
+		backend:        backend,
+		unknownBackend: unknownBackend,
+		dedup:          newDedupCache(100, time.Minute),
+		retry:          newRetryPolicy(config),
+		deadLetter:     deadLetter,
+		wg:             new(sync.WaitGroup),
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		p.Run(ctx)
+		close(done)
+	}()
+
+	bqEvents <- types.EventMsg{
+		SenderID: "sender-1",
+		Event: types.Event{
+			TypeField:    types.Type{EventNameField: "transaction", EventVersionField: "1"},
+			PayloadField: types.Payload{"action": "buy", "price": 1.0, "date": "today"},
+		},
+	}
+	time.Sleep(10 * time.Millisecond) // let Run pick the event up before we cancel
+
+	cancel()
+
+	select {
+	case messages := <-published:
+		if len(messages) != 1 {
+			t.Fatalf("want 1 flushed message, got %d", len(messages))
+		}
+	case <-time.After(time.Second):
+		t.Fatal("pending batch was not flushed on shutdown")
+	}
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Run did not return after ctx cancellation")
+	}
+
+	if !backend.closed {
+		t.Fatal("backend was not closed on shutdown")
+	}
+	if !unknownBackend.closed {
+		t.Fatal("unknownBackend was not closed on shutdown")
+	}
+	if !deadLetter.closed {
+		t.Fatal("deadLetter sink was not closed on shutdown")
+	}
+}
+
+func TestRunShutdownFlushBoundedWhenBackendBlocks(t *testing.T) {
+	backend := &fakeBackend{publishFunc: func(ctx context.Context, messages []Message) []Result {
+		<-ctx.Done() // simulate a backend call that hangs until the caller gives up
+		out := make([]Result, len(messages))
+		for i := range out {
+			out[i] = Result{Err: ctx.Err()}
+		}
+		return out
+	}}
+
+	bqEvents := make(chan types.EventMsg, 1)
+	config := &cfg.Config{
+		PubsubMaxBatch:        10,
+		PubsubMaxPublishDelay: time.Hour,
+		ShutdownFlushTimeout:  50 * time.Millisecond,
+		RetryMaxAttempts:      3,
+		RetryInitialBackoff:   time.Hour,
+		RetryMaxBackoff:       time.Hour,
+	}
+	p := &Publisher{
+		bqEvents:   bqEvents,
+		logger:     zap.NewNop(),
+		gp:         pool.NewGoPool(1, time.Second),
+		config:     config,
+		backend:    backend,
+		dedup:      newDedupCache(100, time.Minute),
+		retry:      newRetryPolicy(config),
+		deadLetter: noopDeadLetterSink{},
+		wg:         new(sync.WaitGroup),
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		p.Run(ctx)
+		close(done)
+	}()
+
+	bqEvents <- types.EventMsg{
+		SenderID: "sender-1",
+		Event: types.Event{
+			TypeField:    types.Type{EventNameField: "transaction", EventVersionField: "1"},
+			PayloadField: types.Payload{"action": "buy", "price": 1.0, "date": "today"},
+		},
+	}
+	time.Sleep(10 * time.Millisecond) // let Run pick the event up before we cancel
+
+	start := time.Now()
+	cancel()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Run did not return within a second of a backend that blocks through the whole ShutdownFlushTimeout; ctx is not reaching publishWithRetry/backend.Publish")
+	}
+
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Fatalf("shutdown took %v, want it bounded by ShutdownFlushTimeout (50ms) instead of RetryMaxAttempts*RetryInitialBackoff (hours)", elapsed)
+	}
+}
+
+func TestPublishGivesUpOnInflightSlotWhenCtxDone(t *testing.T) {
+	backend := &fakeBackend{publishFunc: func(ctx context.Context, messages []Message) []Result {
+		return make([]Result, len(messages))
+	}}
+	p := &Publisher{
+		logger:     zap.NewNop(),
+		gp:         pool.NewGoPool(1, time.Second),
+		config:     &cfg.Config{Backend: "gcp"},
+		backend:    backend,
+		deadLetter: noopDeadLetterSink{},
+		retry:      retryPolicy{maxAttempts: 1, initialBackoff: time.Millisecond, maxBackoff: time.Millisecond},
+		inflight:   make(chan struct{}, 1),
+		wg:         new(sync.WaitGroup),
+	}
+	p.inflight <- struct{}{} // saturate the only slot
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	done := make(chan struct{})
+	go func() {
+		p.Publish(ctx, []Message{{Data: []byte("x")}}, time.NewTimer(time.Hour), time.Hour)
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Publish did not return once ctx expired while waiting for an inflight slot")
+	}
+}
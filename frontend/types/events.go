@@ -0,0 +1,89 @@
+package types
+
+import "strconv"
+
+// RegisteredEvent is implemented by concrete event payload types so they
+// can self-register with the package-level EventRegistry. The publisher
+// uses the registry to recognise incoming event names/versions and to
+// compute a stable key for duplicate detection before publishing.
+type RegisteredEvent interface {
+	Name() string
+	Version() string
+	// IdempotencyKey derives a stable, unique-per-occurrence key from an
+	// event's payload, used to drop duplicate deliveries.
+	IdempotencyKey(payload Payload) string
+}
+
+// EventRegistry maps "name/version" to the RegisteredEvent that handles it.
+type EventRegistry struct {
+	events map[string]RegisteredEvent
+}
+
+var registry = &EventRegistry{events: make(map[string]RegisteredEvent)}
+
+// Registry returns the package-level event registry.
+func Registry() *EventRegistry {
+	return registry
+}
+
+// registerEvent adds e to the registry, keyed by its Name()/Version().
+// Concrete event types call this from an init() function.
+func registerEvent(e RegisteredEvent) {
+	registry.events[e.Name()+"/"+e.Version()] = e
+}
+
+// Lookup returns the RegisteredEvent matching t's name and version, if any.
+func (r *EventRegistry) Lookup(t Type) (RegisteredEvent, bool) {
+	e, ok := r.events[t.EventNameField+"/"+t.EventVersionField]
+	return e, ok
+}
+
+// TransactionEvent is the "transaction"/"1" event used in the example
+// payload at the end of types.go.
+type TransactionEvent struct{}
+
+func init() {
+	registerEvent(TransactionEvent{})
+}
+
+func (TransactionEvent) Name() string    { return "transaction" }
+func (TransactionEvent) Version() string { return "1" }
+
+// IdempotencyKey identifies a transaction by its action, price and date,
+// since producers don't currently attach an explicit transaction id.
+func (TransactionEvent) IdempotencyKey(payload Payload) string {
+	action, _ := payload["action"].(string)
+	date, _ := payload["date"].(string)
+	price := payload["price"]
+	return action + "|" + date + "|" + toString(price)
+}
+
+// ClickEvent is the "click"/"2" event used in the example payload at the
+// end of types.go.
+type ClickEvent struct{}
+
+func init() {
+	registerEvent(ClickEvent{})
+}
+
+func (ClickEvent) Name() string    { return "click" }
+func (ClickEvent) Version() string { return "2" }
+
+// IdempotencyKey identifies a click by its screen alone, since producers
+// don't currently attach an explicit click id; the sender is not part of
+// the key.
+func (ClickEvent) IdempotencyKey(payload Payload) string {
+	screen, _ := payload["screen"].(string)
+	return screen
+}
+
+func toString(v interface{}) string {
+	switch n := v.(type) {
+	case string:
+		return n
+	case float64:
+		return strconv.FormatFloat(n, 'f', -1, 64)
+	default:
+		return ""
+	}
+}
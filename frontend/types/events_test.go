@@ -0,0 +1,37 @@
+package types
+
+import "testing"
+
+func TestRegistryLookupKnownEvent(t *testing.T) {
+	registered, ok := Registry().Lookup(Type{EventNameField: "transaction", EventVersionField: "1"})
+	if !ok {
+		t.Fatal("transaction/1 should be registered")
+	}
+	if registered.Name() != "transaction" || registered.Version() != "1" {
+		t.Fatalf("unexpected registered event: %+v", registered)
+	}
+}
+
+func TestRegistryLookupUnknownEvent(t *testing.T) {
+	if _, ok := Registry().Lookup(Type{EventNameField: "does-not-exist", EventVersionField: "1"}); ok {
+		t.Fatal("unregistered event name/version should not be found")
+	}
+}
+
+func TestTransactionEventIdempotencyKey(t *testing.T) {
+	e := TransactionEvent{}
+	key := e.IdempotencyKey(Payload{"action": "buy", "price": 170.0, "date": "03/31/1967"})
+	if want := "buy|03/31/1967|170"; key != want {
+		t.Fatalf("got %q, want %q", key, want)
+	}
+}
+
+func TestClickEventRegistered(t *testing.T) {
+	registered, ok := Registry().Lookup(Type{EventNameField: "click", EventVersionField: "2"})
+	if !ok {
+		t.Fatal("click/2 should be registered")
+	}
+	if got := registered.IdempotencyKey(Payload{"screen": "welcome"}); got != "welcome" {
+		t.Fatalf("got %q, want %q", got, "welcome")
+	}
+}
@@ -2,6 +2,9 @@ package types
 import (
 	"strconv"
 	"strings"
+	"time"
+
+	"github.com/google/uuid"
 )
 
 
@@ -37,6 +40,34 @@ type EventMsg struct  {
 	SenderID string
 	Event    Event
 }
+
+// CloudEvent is a CloudEvents v1.0 envelope. Subscribers that want a
+// standard event schema instead of the ad-hoc EventMsg JSON can consume
+// this shape when Config.CloudEventsEnabled is set.
+type CloudEvent struct {
+	SpecVersion     string      `json:"specversion"`
+	ID              string      `json:"id"`
+	Source          string      `json:"source"`
+	Type            string      `json:"type"`
+	Time            time.Time   `json:"time"`
+	DataContentType string      `json:"datacontenttype"`
+	Subject         string      `json:"subject"`
+	Data            interface{} `json:"data"`
+}
+
+// NewCloudEvent wraps msg in a CloudEvents v1.0 envelope attributed to source.
+func NewCloudEvent(source string, msg EventMsg) CloudEvent {
+	return CloudEvent{
+		SpecVersion:     "1.0",
+		ID:              uuid.New().String(),
+		Source:          source,
+		Type:            msg.Event.TypeField.EventNameField + "." + msg.Event.TypeField.EventVersionField,
+		Time:            time.Now(),
+		DataContentType: "application/json",
+		Subject:         msg.SenderID,
+		Data:            msg.Event.PayloadField,
+	}
+}
 // Payload is the event's actual data inserted into data stores.
 type Payload map[string]interface{}
 